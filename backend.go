@@ -0,0 +1,71 @@
+// Copyright 2020 Giulio Iotti. All rights reserved.
+// This package is provided without warranty; any use is granted by the author.
+
+package filebuf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+// File is the minimal file handle a Backend must provide. It mirrors the
+// subset of afero.File that Filebuf needs in order to spill to and read
+// back from a backing store; *os.File already satisfies it.
+type File interface {
+	Name() string
+	Read(p []byte) (n int, err error)
+	Write(p []byte) (n int, err error)
+	ReadAt(p []byte, off int64) (n int, err error)
+	WriteAt(p []byte, off int64) (n int, err error)
+	Seek(offset int64, whence int) (int64, error)
+	Truncate(size int64) error
+	Close() error
+}
+
+// Backend provides the temporary file storage Filebuf spills to once it
+// outgrows MaxBufSize. Implementations let Filebuf run against sandboxes,
+// in-memory filesystems such as MemBackend, or encrypted spill directories.
+type Backend interface {
+	// CreateTemp creates a new temporary File in dir with a name derived
+	// from pattern, following the same rules as ioutil.TempFile.
+	CreateTemp(dir, pattern string) (File, error)
+	// Remove removes the named file.
+	Remove(name string) error
+	// Dup returns a handle sharing the same backing storage as f, for use
+	// by Clone to give each clone its own, independently seekable File.
+	Dup(f File) (File, error)
+}
+
+// osBackend is the default Backend and reproduces the behavior Filebuf had
+// before Backend was introduced: real temporary files, duplicated with
+// syscall.Dup on Clone.
+type osBackend struct{}
+
+// defaultBackend is used whenever Filebuf.Backend is left nil.
+var defaultBackend Backend = osBackend{}
+
+func (osBackend) CreateTemp(dir, pattern string) (File, error) {
+	return ioutil.TempFile(dir, pattern)
+}
+
+func (osBackend) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osBackend) Dup(f File) (File, error) {
+	osf, ok := f.(*os.File)
+	if !ok {
+		return nil, fmt.Errorf("osBackend: cannot duplicate handle of type %T", f)
+	}
+	fd, err := syscall.Dup(int(osf.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot duplicate handle to backing file: %w", err)
+	}
+	dup := os.NewFile(uintptr(fd), osf.Name())
+	if dup == nil {
+		return nil, fmt.Errorf("could not create new file from descriptor %d", fd)
+	}
+	return dup, nil
+}
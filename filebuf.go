@@ -12,18 +12,24 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
-	"syscall"
 )
 
 // Filebuf provides a scratch space backed by memory or temporary
 // file depending on size of the written data.
 //
 // Intended usage is for writing and then reading back written data.
-// Use Clone() for multiple concurrent readers.
+// Use Clone() for multiple concurrent readers each needing an independent
+// file handle, or NewReader()/NewSectionReader() for many concurrent
+// readers sharing the same handle without per-reader Close.
 //
 // Mixing of concurrent reads and writes is not supported.
+//
+// Filebuf implements io.ReadWriteSeeker, so it can be used wherever a
+// bytes.Buffer or bytes.Reader would be, including http.ServeContent.
+//
+// Close and Reset return the in-memory buffer to a package-level pool
+// bucketed by MaxBufSize, so callers must not retain slices obtained from
+// Read or similar methods past a call to either.
 type Filebuf struct {
 	// Maximum memory size to use before switching to disk files.
 	// Default means using memory only.
@@ -35,10 +41,37 @@ type Filebuf struct {
 	TempDir string
 	// Pattern to use to name temporary files. Same as ioutil.TempFile.
 	TempFilePattern string
+	// Backend provides the temporary file storage used once Filebuf spills
+	// to disk. Nil means the real filesystem, via osBackend.
+	Backend Backend
+	// CopyBufSize is the size of the scratch buffer used to copy directly
+	// into the backing file once Filebuf has spilled to disk. Zero means
+	// use a package default. As with io.CopyBuffer, the scratch buffer is
+	// not used when the backing File implements io.ReaderFrom, as *os.File
+	// does, nor when the source implements io.WriterTo.
+	CopyBufSize int
+	// UseMmap enables memory-mapping the backing file for reads. On unix
+	// it is otherwise enabled automatically once the file grows past a
+	// size threshold; it has no effect on platforms without mmap support,
+	// where Filebuf transparently falls back to regular file I/O.
+	UseMmap bool
 
-	buf  []byte
-	file *os.File
-	off  int64 // offset reading
+	buf       []byte
+	file      File
+	off       int64 // offset reading
+	size      int64 // total bytes written to file, tracked since file has no len()
+	frozen    bool  // set by Freeze, forbids further writes
+	mmap      *mmapRegion
+	mmapTried bool // whether ensureMmap already attempted a mapping
+	noPool    bool // bypasses the buffer pool; only ever set by benchmarks
+}
+
+// backend returns the Backend to use, falling back to the real filesystem.
+func (f *Filebuf) backend() Backend {
+	if f.Backend != nil {
+		return f.Backend
+	}
+	return defaultBackend
 }
 
 // New returns a Filebuf ready to be used. Public parameters can still be
@@ -52,23 +85,24 @@ func New(size int) *Filebuf {
 }
 
 func (f *Filebuf) moveToFile() error {
-	var err error
-	f.file, err = ioutil.TempFile(f.TempDir, f.TempFilePattern)
+	file, err := f.backend().CreateTemp(f.TempDir, f.TempFilePattern)
 	if err != nil {
 		return fmt.Errorf("cannot open backing temporary file: %w", err)
 	}
-	if err = os.Remove(f.file.Name()); err != nil && !f.IgnoreDeleteErr {
+	f.file = file
+	if err = f.backend().Remove(f.file.Name()); err != nil && !f.IgnoreDeleteErr {
 		return fmt.Errorf("cannot delete backing temporary file: %w", err)
 	}
 	if _, err = f.file.Write(f.buf); err != nil {
 		return fmt.Errorf("cannot copy to backing temporary file: %w", err)
 	}
+	f.size = int64(len(f.buf))
 	return nil
 }
 
 func (f *Filebuf) appendBuffer(p []byte) {
 	if f.buf == nil {
-		f.buf = make([]byte, 0, f.MaxBufSize)
+		f.buf = getBuf(f.MaxBufSize, f.noPool)
 	}
 	// append is guaranteed to be called within cap boundaries
 	l := len(f.buf)
@@ -76,75 +110,119 @@ func (f *Filebuf) appendBuffer(p []byte) {
 	copy(f.buf[l:], p)
 }
 
-func (f *Filebuf) copyBuffer(p []byte) int {
-	end := int(f.off) + len(p)
+func (f *Filebuf) copyBufferAt(p []byte, pos int64) int {
+	end := int(pos) + len(p)
 	if end > len(f.buf) {
 		end = len(f.buf)
 	}
-	copy(p, f.buf[f.off:end])
-	return end - int(f.off)
+	copy(p, f.buf[pos:end])
+	return end - int(pos)
 }
 
 // Write writes to the backing buffer or disk file and returns the
-// number of written bytes or an error.
+// number of written bytes or an error. Write panics if the Filebuf has
+// been frozen by Freeze, NewReader or NewSectionReader.
 func (f *Filebuf) Write(p []byte) (n int, err error) {
+	if f.frozen {
+		panic("filebuf: Write called on a frozen Filebuf")
+	}
 	if f.file != nil {
-		return f.file.Write(p)
+		n, err = f.file.Write(p)
+		f.size += int64(n)
+		f.invalidateMmap()
+		return n, err
 	}
 	if f.MaxBufSize > 0 && len(f.buf)+len(p) > f.MaxBufSize {
 		if err := f.moveToFile(); err != nil {
 			return 0, err
 		}
+		putBuf(f.MaxBufSize, f.buf, f.noPool)
 		f.buf = nil
-		return f.file.Write(p)
+		n, err = f.file.Write(p)
+		f.size += int64(n)
+		f.invalidateMmap()
+		return n, err
 	}
 	f.appendBuffer(p)
 	return len(p), nil
 }
 
-// Read reads in slice p and returns the number of bytes read or and error.
-func (f *Filebuf) Read(p []byte) (n int, err error) {
+// readAt reads at an absolute position without touching f.off, so it can
+// be shared by Read, ReadAt and the readers returned by NewReader. Once
+// the backing file is memory-mapped, reads are served from the mapping.
+func (f *Filebuf) readAt(p []byte, pos int64) (int, error) {
 	if f.file != nil {
-		n, err := f.file.ReadAt(p, f.off)
-		f.off += int64(n)
-		return n, err
+		if region := f.ensureMmap(); region != nil {
+			return region.readAt(p, pos)
+		}
+		return f.file.ReadAt(p, pos)
 	}
-	if int(f.off) >= len(f.buf) {
+	if pos >= int64(len(f.buf)) {
 		return 0, io.EOF
 	}
-	n = f.copyBuffer(p)
+	return f.copyBufferAt(p, pos), nil
+}
+
+// Read reads in slice p and returns the number of bytes read or and error.
+func (f *Filebuf) Read(p []byte) (n int, err error) {
+	n, err = f.readAt(p, f.off)
 	f.off += int64(n)
-	return n, nil
+	return n, err
 }
 
-// ReadAt reads up to len(p) bytes at position pos. ReadAt is not safe for concurrent usage.
+// ReadAt reads up to len(p) bytes at position pos. Unlike Read, ReadAt
+// does not use or change the Filebuf's current read offset, so it is safe
+// to call concurrently with other ReadAt calls (but not with Write).
+// When mmap is in use, the first read sets up the mapping, so concurrent
+// ReadAt calls are only safe once Freeze has been called.
 func (f *Filebuf) ReadAt(p []byte, pos int64) (n int, err error) {
-	off := f.off
-	f.off = pos
-	n, err = f.Read(p)
-	f.off = off
-	return n, err
+	return f.readAt(p, pos)
+}
+
+// copyFromReader copies r into dst, preferring r's WriteTo method over
+// io.Copy's staging buffer when r implements io.WriterTo. Otherwise it
+// calls io.CopyBuffer with a scratch buffer pulled from a pool sized by
+// CopyBufSize; io.CopyBuffer leaves that buffer unused and calls dst's
+// ReadFrom instead when dst implements io.ReaderFrom.
+func (f *Filebuf) copyFromReader(dst io.Writer, r io.Reader) (int64, error) {
+	if wt, ok := r.(io.WriterTo); ok {
+		return wt.WriteTo(dst)
+	}
+	size := f.CopyBufSize
+	if size <= 0 {
+		size = defaultCopyBufSize
+	}
+	scratch := getScratch(size, f.noPool)
+	defer putScratch(size, scratch, f.noPool)
+	return io.CopyBuffer(dst, r, scratch)
 }
 
 // ReadFrom reads r in full into the backing buffer or file. Returns the
-// number of read bytes or an error.
+// number of read bytes or an error. ReadFrom panics if the Filebuf has
+// been frozen by Freeze, NewReader or NewSectionReader.
 func (f *Filebuf) ReadFrom(r io.Reader) (n int64, err error) {
+	if f.frozen {
+		panic("filebuf: ReadFrom called on a frozen Filebuf")
+	}
 	if f.file != nil {
-		return io.Copy(f.file, r)
+		n, err = f.copyFromReader(f.file, r)
+		f.size += n
+		f.invalidateMmap()
+		return n, err
 	}
 	if f.MaxBufSize == 0 {
 		b := new(bytes.Buffer)
-		n, err = io.Copy(b, r)
+		n, err = f.copyFromReader(b, r)
 		f.buf = append(f.buf, b.Bytes()...)
 		return n, err
 	}
 	if f.buf == nil {
-		f.buf = make([]byte, 0, f.MaxBufSize)
+		f.buf = getBuf(f.MaxBufSize, f.noPool)
 	}
 	var tot int64
 	// read until limit; if limit is hit, switch to file to continue copying
 	for {
-		m, err := r.Read(f.buf[len(f.buf) : cap(f.buf)-len(f.buf)])
+		m, err := r.Read(f.buf[len(f.buf):cap(f.buf)])
 		f.buf = f.buf[:len(f.buf)+m]
 		tot += int64(m)
 		if err != nil {
@@ -161,19 +239,34 @@ func (f *Filebuf) ReadFrom(r io.Reader) (n int64, err error) {
 	if err := f.moveToFile(); err != nil {
 		return 0, err
 	}
+	putBuf(f.MaxBufSize, f.buf, f.noPool)
 	f.buf = nil
-	n, err = io.Copy(f.file, r)
+	n, err = f.copyFromReader(f.file, r)
+	f.size += n
+	f.invalidateMmap()
 	return n + int64(m), err
 }
 
+// copyToWriter copies src into dst, preferring dst's ReadFrom method over
+// io.Copy's staging buffer when dst implements io.ReaderFrom.
+func copyToWriter(dst io.Writer, src io.Reader) (int64, error) {
+	if rf, ok := dst.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(dst, src)
+}
+
 // WriteTo writes the full contents of the buffer into w. Returns the
 // number of written bytes or an error.
 func (f *Filebuf) WriteTo(w io.Writer) (n int64, err error) {
 	if f.file != nil {
+		if region := f.ensureMmap(); region != nil {
+			return region.writeTo(w, f.off)
+		}
 		if _, err := f.file.Seek(f.off, 0); err != nil {
 			return 0, fmt.Errorf("cannot seek in backing file: %w", err)
 		}
-		return io.Copy(w, f.file)
+		return copyToWriter(w, f.file)
 	}
 	m, err := w.Write(f.buf[f.off:])
 	return int64(m), err
@@ -190,6 +283,129 @@ func (f *Filebuf) Rewind() error {
 	return nil
 }
 
+// Seek sets the offset for the next Read or WriteTo, interpreted according to
+// whence: io.SeekStart, io.SeekCurrent or io.SeekEnd. It returns the new
+// offset relative to the start of the buffer and implements io.Seeker.
+func (f *Filebuf) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.off + offset
+	case io.SeekEnd:
+		abs = f.Size() + offset
+	default:
+		return 0, fmt.Errorf("filebuf: invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("filebuf: negative position")
+	}
+	if f.file != nil {
+		if _, err := f.file.Seek(abs, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("cannot seek in backing file: %w", err)
+		}
+	}
+	f.off = abs
+	return abs, nil
+}
+
+// Len returns the number of unread bytes remaining in the buffer.
+func (f *Filebuf) Len() int {
+	return int(f.Size() - f.off)
+}
+
+// Size returns the total number of bytes currently stored in the buffer,
+// regardless of how much of it has already been read.
+func (f *Filebuf) Size() int64 {
+	if f.file != nil {
+		return f.size
+	}
+	return int64(len(f.buf))
+}
+
+// Cap returns the capacity of the in-memory buffer. It returns 0 once the
+// buffer has switched to a backing temporary file.
+func (f *Filebuf) Cap() int {
+	if f.file != nil {
+		return 0
+	}
+	return cap(f.buf)
+}
+
+// Truncate discards all but the first n bytes of the buffer's contents.
+// It returns an error if n is negative or greater than the current size.
+// The read offset is clamped to n if it now points past the end.
+func (f *Filebuf) Truncate(n int64) error {
+	if n < 0 || n > f.Size() {
+		return fmt.Errorf("filebuf: truncation out of range")
+	}
+	if f.file != nil {
+		if err := f.file.Truncate(n); err != nil {
+			return fmt.Errorf("cannot truncate backing file: %w", err)
+		}
+		f.size = n
+		f.invalidateMmap()
+	} else {
+		f.buf = f.buf[:n]
+	}
+	if f.off > n {
+		f.off = n
+	}
+	return nil
+}
+
+// Reset discards the buffer's contents. A backing temporary file, if any,
+// is truncated and kept open so the Filebuf can be reused.
+func (f *Filebuf) Reset() {
+	f.off = 0
+	if f.file != nil {
+		f.file.Truncate(0)
+		f.file.Seek(0, io.SeekStart)
+		f.size = 0
+		f.invalidateMmap()
+		return
+	}
+	putBuf(f.MaxBufSize, f.buf, f.noPool)
+	f.buf = nil
+}
+
+// Freeze marks the Filebuf as read-only: subsequent calls to Write or
+// ReadFrom panic. It is idempotent and safe to call more than once.
+//
+// Freeze makes the read/write invariant explicit for the common "write
+// once, fan out to many readers" case; NewReader and NewSectionReader
+// call it automatically.
+//
+// Freeze also establishes any mmap mapping up front, since once frozen
+// Write and ReadFrom can no longer change the backing file: this keeps
+// ensureMmap a read-only check on every later, possibly concurrent, call
+// from readAt. Truncate and Reset are not blocked by Freeze; they drop
+// the mapping, and the next read maps the file again.
+func (f *Filebuf) Freeze() {
+	f.frozen = true
+	f.ensureMmap()
+}
+
+// NewReader returns an io.ReadSeeker over the Filebuf's contents, sharing
+// the underlying buffer or file via ReadAt and tracking its own offset
+// independently of the Filebuf and of any other reader. It calls Freeze.
+//
+// Unlike Clone, NewReader needs no duplicated file handle and no Close,
+// so it scales to many concurrent readers without exhausting file
+// descriptors.
+func (f *Filebuf) NewReader() io.ReadSeeker {
+	f.Freeze()
+	return io.NewSectionReader(f, 0, f.Size())
+}
+
+// NewSectionReader is like NewReader but limits reading to the n bytes
+// starting at off. It calls Freeze.
+func (f *Filebuf) NewSectionReader(off, n int64) *io.SectionReader {
+	f.Freeze()
+	return io.NewSectionReader(f, off, n)
+}
+
 // Clone creates a new Filebuf sharing the same backing memory and a duplicated file handle of
 // the same temporary backing file, if used.
 //
@@ -201,15 +417,21 @@ func (f *Filebuf) Rewind() error {
 func (f *Filebuf) Clone() (*Filebuf, error) {
 	fb := *f
 	if fb.file != nil {
-		fd, err := syscall.Dup(int(f.file.Fd()))
+		dup, err := f.backend().Dup(f.file)
 		if err != nil {
-			return nil, fmt.Errorf("cannot duplicate handle to backing file: %w", err)
-		}
-		name := f.file.Name()
-		fb.file = os.NewFile(uintptr(fd), name)
-		if fb.file == nil {
-			return nil, fmt.Errorf("could not create new file from descriptor %d", fd)
+			return nil, err
 		}
+		fb.file = dup
+		// Each clone maps the file independently on first read, so closing
+		// one clone's mapping never affects another's.
+		fb.mmap = nil
+		fb.mmapTried = false
+	} else if fb.buf != nil {
+		// Deep-copy the in-memory buffer: Close and Reset return it to the
+		// pool, so the original and the clone must not share one backing
+		// array, or closing both hands the same array to two later callers.
+		buf := getBuf(f.MaxBufSize, f.noPool)
+		fb.buf = append(buf, f.buf...)
 	}
 	return &fb, nil
 }
@@ -217,8 +439,15 @@ func (f *Filebuf) Clone() (*Filebuf, error) {
 // Close closes the underlying buffer or file. Closing might return an error.
 func (f *Filebuf) Close() error {
 	if f.file != nil {
+		if f.mmap != nil {
+			if err := f.mmap.Close(); err != nil {
+				return err
+			}
+			f.mmap = nil
+		}
 		return f.file.Close()
 	}
+	putBuf(f.MaxBufSize, f.buf, f.noPool)
 	f.buf = nil
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha1"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"testing"
@@ -149,3 +150,369 @@ func TestClone(t *testing.T) {
 		t.Fatalf("unexpected error closing filebuf: %v", err)
 	}
 }
+
+// TestCloneInMemoryNoAliasing guards against the original and a clone of
+// an in-memory Filebuf sharing one backing array: Close and Reset return
+// that array to the pool, so if it were shared, closing both would hand
+// the same array to two later, unrelated Filebufs of the same MaxBufSize.
+func TestCloneInMemoryNoAliasing(t *testing.T) {
+	const max = 1 << 8
+
+	fb := &Filebuf{MaxBufSize: max}
+	if _, err := fb.Write(bytes.Repeat([]byte{0xAA}, 100)); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if fb.file != nil {
+		t.Fatalf("expected in-memory buffer, got a backing file")
+	}
+
+	clone, err := fb.Clone()
+	if err != nil {
+		t.Fatalf("unexpected error cloning Filebuf: %v", err)
+	}
+	if err := fb.Close(); err != nil {
+		t.Fatalf("unexpected error closing filebuf: %v", err)
+	}
+	if err := clone.Close(); err != nil {
+		t.Fatalf("unexpected error closing clone: %v", err)
+	}
+
+	fb1 := &Filebuf{MaxBufSize: max}
+	if _, err := fb1.Write(bytes.Repeat([]byte{0x01}, 100)); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	fb2 := &Filebuf{MaxBufSize: max}
+	if _, err := fb2.Write(bytes.Repeat([]byte{0x02}, 100)); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+
+	got1 := make([]byte, 100)
+	if _, err := fb1.ReadAt(got1, 0); err != nil {
+		t.Fatalf("unexpected error reading fb1: %v", err)
+	}
+	if !bytes.Equal(got1, bytes.Repeat([]byte{0x01}, 100)) {
+		t.Fatalf("fb1 contents corrupted by a shared buffer: %x", got1)
+	}
+
+	got2 := make([]byte, 100)
+	if _, err := fb2.ReadAt(got2, 0); err != nil {
+		t.Fatalf("unexpected error reading fb2: %v", err)
+	}
+	if !bytes.Equal(got2, bytes.Repeat([]byte{0x02}, 100)) {
+		t.Fatalf("fb2 contents corrupted by a shared buffer: %x", got2)
+	}
+
+	if err := fb1.Close(); err != nil {
+		t.Fatalf("unexpected error closing fb1: %v", err)
+	}
+	if err := fb2.Close(); err != nil {
+		t.Fatalf("unexpected error closing fb2: %v", err)
+	}
+}
+
+func TestSeekLenSizeCap(t *testing.T) {
+	for _, max := range []int{1 << 11, 1 << 8} {
+		fb := &Filebuf{MaxBufSize: max}
+		data := make([]byte, 1<<10)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatalf("unexpected error generating random data: %v", err)
+		}
+		if _, err := fb.Write(data); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+
+		if got := fb.Size(); got != int64(len(data)) {
+			t.Fatalf("Size() = %d, want %d", got, len(data))
+		}
+		if got := fb.Len(); got != len(data) {
+			t.Fatalf("Len() = %d, want %d", got, len(data))
+		}
+
+		abs, err := fb.Seek(10, io.SeekStart)
+		if err != nil {
+			t.Fatalf("unexpected error seeking: %v", err)
+		}
+		if abs != 10 {
+			t.Fatalf("Seek(io.SeekStart) = %d, want 10", abs)
+		}
+		if got := fb.Len(); got != len(data)-10 {
+			t.Fatalf("Len() after seek = %d, want %d", got, len(data)-10)
+		}
+
+		abs, err = fb.Seek(-5, io.SeekEnd)
+		if err != nil {
+			t.Fatalf("unexpected error seeking from end: %v", err)
+		}
+		if abs != int64(len(data))-5 {
+			t.Fatalf("Seek(io.SeekEnd) = %d, want %d", abs, int64(len(data))-5)
+		}
+
+		p := make([]byte, 5)
+		if _, err := io.ReadFull(fb, p); err != nil {
+			t.Fatalf("unexpected error reading tail: %v", err)
+		}
+		if !bytes.Equal(p, data[len(data)-5:]) {
+			t.Fatalf("read data mismatch after seek")
+		}
+
+		if _, err := fb.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error seeking to start: %v", err)
+		}
+		if err := fb.Truncate(100); err != nil {
+			t.Fatalf("unexpected error truncating: %v", err)
+		}
+		if got := fb.Size(); got != 100 {
+			t.Fatalf("Size() after truncate = %d, want 100", got)
+		}
+		if err := fb.Truncate(-1); err == nil {
+			t.Fatalf("expected error truncating to negative size")
+		}
+		if err := fb.Truncate(int64(fb.Size()) + 1); err == nil {
+			t.Fatalf("expected error truncating past current size")
+		}
+
+		fb.Reset()
+		if got := fb.Size(); got != 0 {
+			t.Fatalf("Size() after reset = %d, want 0", got)
+		}
+		if got := fb.Cap(); got != 0 {
+			t.Fatalf("Cap() right after reset = %d, want 0: buffer is released to the pool, not retained", got)
+		}
+		if _, err := fb.Write(data[:10]); err != nil {
+			t.Fatalf("unexpected error writing after reset: %v", err)
+		}
+		if got := fb.Size(); got != 10 {
+			t.Fatalf("Size() after reuse = %d, want 10", got)
+		}
+		wantCap := 0
+		if fb.file == nil {
+			wantCap = max
+		}
+		if got := fb.Cap(); got != wantCap {
+			t.Fatalf("Cap() after reuse = %d, want %d", got, wantCap)
+		}
+		if err := fb.Close(); err != nil {
+			t.Fatalf("unexpected error closing filebuf: %v", err)
+		}
+	}
+}
+
+// countingWriterTo wraps a reader and counts calls to WriteTo, so tests can
+// assert that ReadFrom took the fast path instead of looping on Read.
+type countingWriterTo struct {
+	io.Reader
+	calls *int
+}
+
+func (c countingWriterTo) WriteTo(w io.Writer) (int64, error) {
+	*c.calls++
+	return io.Copy(w, c.Reader)
+}
+
+// countingReaderFrom wraps a writer and counts calls to ReadFrom, so tests
+// can assert that WriteTo took the fast path instead of looping on Write.
+type countingReaderFrom struct {
+	io.Writer
+	calls *int
+}
+
+func (c countingReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	*c.calls++
+	return io.Copy(c.Writer, r)
+}
+
+func TestReadFromWriteToFastPath(t *testing.T) {
+	t.Run("ReadFrom into already-spilled file", func(t *testing.T) {
+		fb := &Filebuf{MaxBufSize: 1 << 8}
+		if _, err := fb.Write(make([]byte, 1<<9)); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+		if fb.file == nil {
+			t.Fatalf("expected usage of file in big write")
+		}
+
+		calls := 0
+		src := &io.LimitedReader{R: rand.Reader, N: 1 << 10}
+		if _, err := fb.ReadFrom(countingWriterTo{src, &calls}); err != nil {
+			t.Fatalf("unexpected error in ReadFrom: %v", err)
+		}
+		if calls != 1 {
+			t.Fatalf("WriteTo fast path calls = %d, want 1", calls)
+		}
+		if err := fb.Close(); err != nil {
+			t.Fatalf("unexpected error closing filebuf: %v", err)
+		}
+	})
+
+	t.Run("ReadFrom with unbounded memory buffer", func(t *testing.T) {
+		fb := &Filebuf{}
+
+		calls := 0
+		src := &io.LimitedReader{R: rand.Reader, N: 1 << 10}
+		if _, err := fb.ReadFrom(countingWriterTo{src, &calls}); err != nil {
+			t.Fatalf("unexpected error in ReadFrom: %v", err)
+		}
+		if calls != 1 {
+			t.Fatalf("WriteTo fast path calls = %d, want 1", calls)
+		}
+		if err := fb.Close(); err != nil {
+			t.Fatalf("unexpected error closing filebuf: %v", err)
+		}
+	})
+
+	t.Run("WriteTo from spilled file", func(t *testing.T) {
+		fb := &Filebuf{MaxBufSize: 1 << 8}
+		if _, err := fb.Write(make([]byte, 1<<9)); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+		if fb.file == nil {
+			t.Fatalf("expected usage of file in big write")
+		}
+
+		calls := 0
+		dst := countingReaderFrom{ioutil.Discard, &calls}
+		if _, err := fb.WriteTo(dst); err != nil {
+			t.Fatalf("unexpected error in WriteTo: %v", err)
+		}
+		if calls != 1 {
+			t.Fatalf("ReadFrom fast path calls = %d, want 1", calls)
+		}
+		if err := fb.Close(); err != nil {
+			t.Fatalf("unexpected error closing filebuf: %v", err)
+		}
+	})
+}
+
+// chunkReader returns data in fixed-size chunks and deliberately does not
+// implement io.WriterTo, so ReadFrom must use its fill-loop slow path.
+type chunkReader struct {
+	data  []byte
+	chunk int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := c.chunk
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	n = copy(p, c.data[:n])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+// TestReadFromChunkedReader exercises ReadFrom's fill loop (the non-fast
+// path, taken when the source does not implement io.WriterTo) with a chunk
+// size smaller than the buffer, so that several Reads fill the buffer past
+// half of its capacity, which previously made the next iteration's slice
+// bound empty or invalid.
+func TestReadFromChunkedReader(t *testing.T) {
+	const size = 256
+	data := make([]byte, 200)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("unexpected error generating random data: %v", err)
+	}
+
+	fb := &Filebuf{MaxBufSize: size}
+	src := &chunkReader{data: data, chunk: 64}
+	n, err := fb.ReadFrom(src)
+	if err != nil {
+		t.Fatalf("unexpected error in ReadFrom: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("ReadFrom returned n = %d, want %d", n, len(data))
+	}
+
+	got := make([]byte, len(data))
+	if _, err := fb.ReadAt(got, 0); err != nil {
+		t.Fatalf("unexpected error in ReadAt: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt returned unexpected data")
+	}
+	if err := fb.Close(); err != nil {
+		t.Fatalf("unexpected error closing filebuf: %v", err)
+	}
+}
+
+func TestNewReaderConcurrent(t *testing.T) {
+	for _, max := range []int{1 << 16, 1 << 8} {
+		fb := &Filebuf{MaxBufSize: max}
+		data := make([]byte, 1<<12)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatalf("unexpected error generating random data: %v", err)
+		}
+		if _, err := fb.Write(data); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+
+		const readers = 8
+		errs := make(chan error, readers)
+		for i := 0; i < readers; i++ {
+			r := fb.NewReader()
+			go func() {
+				s, n, err := readSum(r)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if n != int64(len(data)) {
+					errs <- fmt.Errorf("read %d bytes, want %d", n, len(data))
+					return
+				}
+				h := sha1.Sum(data)
+				if !bytes.Equal(s, h[:]) {
+					errs <- fmt.Errorf("checksum mismatch")
+					return
+				}
+				errs <- nil
+			}()
+		}
+		for i := 0; i < readers; i++ {
+			if err := <-errs; err != nil {
+				t.Fatalf("reader error: %v", err)
+			}
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected Write on frozen Filebuf to panic")
+				}
+			}()
+			fb.Write([]byte("x"))
+		}()
+
+		if err := fb.Close(); err != nil {
+			t.Fatalf("unexpected error closing filebuf: %v", err)
+		}
+	}
+}
+
+func TestNewSectionReader(t *testing.T) {
+	fb := &Filebuf{MaxBufSize: 1 << 8}
+	data := make([]byte, 1<<9)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("unexpected error generating random data: %v", err)
+	}
+	if _, err := fb.Write(data); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+
+	sr := fb.NewSectionReader(10, 20)
+	got := make([]byte, 20)
+	if _, err := io.ReadFull(sr, got); err != nil {
+		t.Fatalf("unexpected error reading section: %v", err)
+	}
+	if !bytes.Equal(got, data[10:30]) {
+		t.Fatalf("section contents mismatch")
+	}
+	if err := fb.Close(); err != nil {
+		t.Fatalf("unexpected error closing filebuf: %v", err)
+	}
+}
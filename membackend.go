@@ -0,0 +1,149 @@
+// Copyright 2020 Giulio Iotti. All rights reserved.
+// This package is provided without warranty; any use is granted by the author.
+
+package filebuf
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// MemBackend is a Backend that keeps temporary files entirely in memory.
+// It is meant for tests and sandboxes where spilling to the real
+// filesystem is undesirable or unavailable. The zero value is ready to use.
+type MemBackend struct {
+	mu    sync.Mutex
+	files int
+}
+
+// CreateTemp creates a new in-memory File. dir and pattern are only used to
+// build the returned File's Name, as there is no real filesystem backing it.
+func (b *MemBackend) CreateTemp(dir, pattern string) (File, error) {
+	b.mu.Lock()
+	b.files++
+	name := fmt.Sprintf("%s/%s%d", dir, pattern, b.files)
+	b.mu.Unlock()
+	return &memFile{name: name}, nil
+}
+
+// Remove is a no-op: in-memory files have no presence to clean up once
+// their last handle is closed.
+func (b *MemBackend) Remove(name string) error {
+	return nil
+}
+
+// Dup returns a new handle sharing the same in-memory contents as f, with
+// its own independent read/write offset.
+func (b *MemBackend) Dup(f File) (File, error) {
+	mf, ok := f.(*memFile)
+	if !ok {
+		return nil, fmt.Errorf("MemBackend: cannot duplicate handle of type %T", f)
+	}
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	return &memFile{name: mf.name, buf: mf.buf}, nil
+}
+
+// memFile is the File implementation backing MemBackend.
+type memFile struct {
+	mu   sync.Mutex
+	name string
+	buf  []byte
+	off  int64
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) readAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.readAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *memFile) writeAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if end > int64(len(f.buf)) {
+		grown := make([]byte, end)
+		copy(grown, f.buf)
+		f.buf = grown
+	}
+	copy(f.buf[off:end], p)
+	return len(p), nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.writeAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writeAt(p, off)
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.off + offset
+	case io.SeekEnd:
+		abs = int64(len(f.buf)) + offset
+	default:
+		return 0, fmt.Errorf("memFile: invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("memFile: negative position")
+	}
+	f.off = abs
+	return abs, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size < 0 {
+		return fmt.Errorf("memFile: negative truncation size")
+	}
+	if size <= int64(len(f.buf)) {
+		f.buf = f.buf[:size]
+		return nil
+	}
+	grown := make([]byte, size)
+	copy(grown, f.buf)
+	f.buf = grown
+	return nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
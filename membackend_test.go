@@ -0,0 +1,47 @@
+package filebuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestMemBackend(t *testing.T) {
+	fb := &Filebuf{MaxBufSize: 1 << 8, Backend: &MemBackend{}}
+	src := &io.LimitedReader{R: rand.Reader, N: 1 << 12}
+
+	s1, n1, err := copySum(src, fb)
+	if err != nil {
+		t.Fatalf("unexpected error filling Filebuf: %v", err)
+	}
+	if fb.file == nil {
+		t.Fatalf("expected usage of backing file in big read")
+	}
+	if _, ok := fb.file.(*memFile); !ok {
+		t.Fatalf("expected backing file to be a %T, got %T", &memFile{}, fb.file)
+	}
+
+	fbr, err := fb.Clone()
+	if err != nil {
+		t.Fatalf("unexpected error cloning Filebuf: %v", err)
+	}
+
+	s2, n2, err := readSum(fbr)
+	if err != nil {
+		t.Fatalf("unexpected error reading Filebuf: %v", err)
+	}
+	if n1 != n2 {
+		t.Fatalf("wrote %d bytes, but read %d", n1, n2)
+	}
+	if bytes.Compare(s1, s2) != 0 {
+		t.Fatalf("checksums mismatch: %x != %x", s1, s2)
+	}
+
+	if err := fb.Close(); err != nil {
+		t.Fatalf("unexpected error closing filebuf: %v", err)
+	}
+	if err := fbr.Close(); err != nil {
+		t.Fatalf("unexpected error closing cloned filebuf: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Giulio Iotti. All rights reserved.
+// This package is provided without warranty; any use is granted by the author.
+
+package filebuf
+
+import "io"
+
+// mmapAutoThreshold is the backing file size above which Filebuf enables
+// mmap-backed reads automatically, even if UseMmap was left false.
+const mmapAutoThreshold = 4 << 20 // 4 MiB
+
+// mmapRegion is a memory-mapped, read-only view of a backing file's
+// contents. Platform-specific code creates and releases the mapping;
+// reading from it is the same everywhere.
+type mmapRegion struct {
+	data []byte
+}
+
+func (m *mmapRegion) readAt(p []byte, pos int64) (int, error) {
+	if pos >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[pos:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *mmapRegion) writeTo(w io.Writer, pos int64) (int64, error) {
+	if pos >= int64(len(m.data)) {
+		return 0, nil
+	}
+	n, err := w.Write(m.data[pos:])
+	return int64(n), err
+}
+
+// ensureMmap lazily memory-maps the backing file for reads, the first
+// time it is needed, if UseMmap is set or the file has grown past
+// mmapAutoThreshold. It returns nil if mmap is disabled, unsupported, or
+// failed to set up, in which case callers fall back to regular file I/O.
+func (f *Filebuf) ensureMmap() *mmapRegion {
+	if f.mmap != nil {
+		return f.mmap
+	}
+	if f.mmapTried || f.file == nil {
+		return nil
+	}
+	if !f.UseMmap && f.size < mmapAutoThreshold {
+		return nil
+	}
+	f.mmapTried = true
+	region, err := mmapFile(f.file, f.size)
+	if err != nil {
+		return nil
+	}
+	f.mmap = region
+	return f.mmap
+}
+
+// invalidateMmap releases any mapping set up by ensureMmap, so that the
+// next read remaps over the file's current contents. It must be called
+// whenever the backing file's contents change.
+func (f *Filebuf) invalidateMmap() {
+	if f.mmap != nil {
+		f.mmap.Close()
+		f.mmap = nil
+	}
+	f.mmapTried = false
+}
@@ -0,0 +1,19 @@
+// Copyright 2020 Giulio Iotti. All rights reserved.
+// This package is provided without warranty; any use is granted by the author.
+
+//go:build !unix
+
+package filebuf
+
+import "fmt"
+
+// mmapFile is unsupported outside unix: Filebuf falls back to regular
+// file I/O, via ensureMmap returning nil.
+func mmapFile(file File, size int64) (*mmapRegion, error) {
+	return nil, fmt.Errorf("mmap: not supported on this platform")
+}
+
+// Close is a no-op since mmapFile never actually maps anything here.
+func (m *mmapRegion) Close() error {
+	return nil
+}
@@ -0,0 +1,152 @@
+package filebuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestUseMmapReadAt(t *testing.T) {
+	data := make([]byte, 1<<12)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	fb := &Filebuf{MaxBufSize: 1 << 8, UseMmap: true}
+	if _, err := fb.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	defer fb.Close()
+
+	got := make([]byte, len(data))
+	if _, err := fb.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt via mmap returned unexpected data")
+	}
+
+	if err := fb.Truncate(4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fb.ReadAt(got[:4], 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:4], data[:4]) {
+		t.Fatalf("ReadAt after Truncate returned stale mmap data")
+	}
+}
+
+func TestUseMmapFallsBackOnMemBackend(t *testing.T) {
+	data := make([]byte, 1<<12)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	fb := &Filebuf{MaxBufSize: 1 << 8, UseMmap: true, Backend: &MemBackend{}}
+	if _, err := fb.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	defer fb.Close()
+
+	got := make([]byte, len(data))
+	if _, err := fb.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt without mmap support returned unexpected data")
+	}
+}
+
+// TestNewReaderConcurrentMmap exercises NewReader's lock-free multi-reader
+// mode together with UseMmap: Freeze must establish the mapping before any
+// reader is handed out, so that concurrent ReadAt calls only ever hit
+// read-only checks in ensureMmap. Run with -race to catch regressions.
+func TestNewReaderConcurrentMmap(t *testing.T) {
+	fb := &Filebuf{MaxBufSize: 1 << 8, UseMmap: true}
+	data := make([]byte, 1<<12)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("unexpected error generating random data: %v", err)
+	}
+	if _, err := fb.Write(data); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	defer fb.Close()
+
+	const readers = 8
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		r := fb.NewReader()
+		go func() {
+			h := sha1.New()
+			buf := make([]byte, 257) // deliberately not a divisor of len(data)
+			for {
+				n, err := r.Read(buf)
+				h.Write(buf[:n])
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					errs <- err
+					return
+				}
+			}
+			want := sha1.Sum(data)
+			if !bytes.Equal(h.Sum(nil), want[:]) {
+				errs <- fmt.Errorf("checksum mismatch")
+				return
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < readers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("reader error: %v", err)
+		}
+	}
+}
+
+func TestUseMmapCloneIndependentMapping(t *testing.T) {
+	data := make([]byte, 1<<12)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	fb := &Filebuf{MaxBufSize: 1 << 8, UseMmap: true}
+	if _, err := fb.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	defer fb.Close()
+
+	got := make([]byte, len(data))
+	if _, err := fb.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := fb.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+
+	if _, err := clone.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt on clone returned unexpected data")
+	}
+
+	// Closing the original must not affect the clone's independent mapping.
+	if err := fb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt on clone after original Close returned unexpected data")
+	}
+}
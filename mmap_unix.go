@@ -0,0 +1,39 @@
+// Copyright 2020 Giulio Iotti. All rights reserved.
+// This package is provided without warranty; any use is granted by the author.
+
+//go:build unix
+
+package filebuf
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mmapFile memory-maps the first size bytes of file's contents for
+// reading. file must be backed by a real *os.File, since mmap needs an
+// OS-level descriptor.
+func mmapFile(file File, size int64) (*mmapRegion, error) {
+	if size == 0 {
+		return &mmapRegion{}, nil
+	}
+	osf, ok := file.(*os.File)
+	if !ok {
+		return nil, fmt.Errorf("mmap: backing file is not an *os.File, got %T", file)
+	}
+	data, err := syscall.Mmap(int(osf.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+	return &mmapRegion{data: data}, nil
+}
+
+// Close unmaps the region. It is safe to call on a zero-sized region that
+// was never actually mapped.
+func (m *mmapRegion) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	return syscall.Munmap(m.data)
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Giulio Iotti. All rights reserved.
+// This package is provided without warranty; any use is granted by the author.
+
+package filebuf
+
+import "sync"
+
+// defaultCopyBufSize is the scratch buffer size used to spill to the
+// backing file when Filebuf.CopyBufSize is left at zero.
+const defaultCopyBufSize = 32 * 1024
+
+// bufPools holds the sync.Pool instances backing the in-memory buffer,
+// bucketed by MaxBufSize so that buffers are only ever reused between
+// Filebufs configured with the same size.
+var bufPools sync.Map // map[int]*sync.Pool
+
+// scratchPools holds the sync.Pool instances backing the scratch buffer
+// used to spill to a file, bucketed by CopyBufSize.
+var scratchPools sync.Map // map[int]*sync.Pool
+
+func poolFor(pools *sync.Map, size int, new func() interface{}) *sync.Pool {
+	if p, ok := pools.Load(size); ok {
+		return p.(*sync.Pool)
+	}
+	p := &sync.Pool{New: new}
+	actual, _ := pools.LoadOrStore(size, p)
+	return actual.(*sync.Pool)
+}
+
+// getBuf returns a zero-length buffer with capacity size, reused from the
+// pool when possible. If noPool is set, it always allocates fresh instead.
+func getBuf(size int, noPool bool) []byte {
+	if noPool {
+		return make([]byte, 0, size)
+	}
+	p := poolFor(&bufPools, size, func() interface{} {
+		return make([]byte, 0, size)
+	})
+	return p.Get().([]byte)[:0]
+}
+
+// putBuf returns buf to the pool bucketed by size, for reuse by a future
+// getBuf call. Callers must not retain buf after calling putBuf. If noPool
+// is set, buf is dropped instead, matching the getBuf call it pairs with.
+// A buf whose capacity is not size is dropped too, so that getBuf only
+// ever hands out buffers of capacity size.
+func putBuf(size int, buf []byte, noPool bool) {
+	if buf == nil || noPool || cap(buf) != size {
+		return
+	}
+	poolFor(&bufPools, size, func() interface{} {
+		return make([]byte, 0, size)
+	}).Put(buf[:0])
+}
+
+// getScratch returns a full-length scratch buffer of size, reused from the
+// pool when possible. If noPool is set, it always allocates fresh instead.
+func getScratch(size int, noPool bool) []byte {
+	if noPool {
+		return make([]byte, size)
+	}
+	p := poolFor(&scratchPools, size, func() interface{} {
+		return make([]byte, size)
+	})
+	return p.Get().([]byte)
+}
+
+// putScratch returns buf to the pool bucketed by size. If noPool is set,
+// buf is dropped instead, matching the getScratch call it pairs with.
+func putScratch(size int, buf []byte, noPool bool) {
+	if noPool {
+		return
+	}
+	poolFor(&scratchPools, size, func() interface{} {
+		return make([]byte, size)
+	}).Put(buf)
+}
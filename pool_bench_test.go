@@ -0,0 +1,24 @@
+package filebuf
+
+import "testing"
+
+func benchmarkWrite(b *testing.B, size int, pooled bool) {
+	data := make([]byte, size)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fb := &Filebuf{MaxBufSize: size, noPool: !pooled}
+		if _, err := fb.Write(data); err != nil {
+			b.Fatalf("unexpected error writing: %v", err)
+		}
+		if err := fb.Close(); err != nil {
+			b.Fatalf("unexpected error closing filebuf: %v", err)
+		}
+	}
+}
+
+func BenchmarkWritePooled4KiB(b *testing.B)    { benchmarkWrite(b, 4<<10, true) }
+func BenchmarkWriteUnpooled4KiB(b *testing.B)  { benchmarkWrite(b, 4<<10, false) }
+func BenchmarkWritePooled64KiB(b *testing.B)   { benchmarkWrite(b, 64<<10, true) }
+func BenchmarkWriteUnpooled64KiB(b *testing.B) { benchmarkWrite(b, 64<<10, false) }
+func BenchmarkWritePooled1MiB(b *testing.B)    { benchmarkWrite(b, 1<<20, true) }
+func BenchmarkWriteUnpooled1MiB(b *testing.B)  { benchmarkWrite(b, 1<<20, false) }
@@ -0,0 +1,48 @@
+package filebuf
+
+import "testing"
+
+func TestBufPoolShape(t *testing.T) {
+	const size = 1 << 10
+	buf := getBuf(size, false)
+	if len(buf) != 0 || cap(buf) != size {
+		t.Fatalf("getBuf returned len=%d cap=%d, want len=0 cap=%d", len(buf), cap(buf), size)
+	}
+	buf = append(buf, 0xAB)
+	putBuf(size, buf, false)
+
+	// putBuf must not panic or corrupt state for subsequent callers, even
+	// though sync.Pool offers no guarantee that this exact buffer is the
+	// one returned next.
+	reused := getBuf(size, false)
+	if len(reused) != 0 || cap(reused) != size {
+		t.Fatalf("getBuf after putBuf returned len=%d cap=%d, want len=0 cap=%d", len(reused), cap(reused), size)
+	}
+}
+
+func TestScratchPoolShape(t *testing.T) {
+	const size = 4096
+	buf := getScratch(size, false)
+	if len(buf) != size {
+		t.Fatalf("getScratch returned len=%d, want %d", len(buf), size)
+	}
+	putScratch(size, buf, false)
+
+	reused := getScratch(size, false)
+	if len(reused) != size {
+		t.Fatalf("getScratch after putScratch returned len=%d, want %d", len(reused), size)
+	}
+}
+
+func TestPutBufNilIsNoop(t *testing.T) {
+	putBuf(1<<10, nil, false)
+}
+
+func TestGetBufNoPoolAllocatesFresh(t *testing.T) {
+	const size = 1 << 10
+	buf := getBuf(size, true)
+	if len(buf) != 0 || cap(buf) != size {
+		t.Fatalf("getBuf returned len=%d cap=%d, want len=0 cap=%d", len(buf), cap(buf), size)
+	}
+	putBuf(size, buf, true)
+}